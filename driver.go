@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Driver identifies which sql.DB driver and DSN shape to use when replaying
+// a log file. The zero value is not valid; use parseDriver to get one.
+type Driver string
+
+const (
+	DriverPostgres Driver = "postgres"
+	DriverMySQL    Driver = "mysql"
+	DriverSQLite   Driver = "sqlite3"
+	DriverMSSQL    Driver = "sqlserver"
+)
+
+// parseDriver validates a -driver flag value against the drivers this tool
+// knows how to build a DSN and extraction regex for.
+func parseDriver(name string) (Driver, error) {
+	switch Driver(name) {
+	case DriverPostgres, DriverMySQL, DriverSQLite, DriverMSSQL:
+		return Driver(name), nil
+	default:
+		return "", fmt.Errorf("unsupported driver %q (want one of postgres, mysql, sqlite3, sqlserver)", name)
+	}
+}
+
+// dsn builds the driver-specific connection string sql.Open expects for
+// dbConfig. SQLite ignores the network/credential fields and treats DBName
+// as a file path.
+func (d Driver) dsn(dbConfig *config) (string, error) {
+	switch d {
+	case DriverPostgres:
+		return fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
+			dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.DBName, dbConfig.Password, dbConfig.SSLMode), nil
+	case DriverMySQL:
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", dbConfig.User, dbConfig.Password, dbConfig.Host, dbConfig.Port, dbConfig.DBName), nil
+	case DriverSQLite:
+		return dbConfig.DBName, nil
+	case DriverMSSQL:
+		return fmt.Sprintf("server=%s;port=%s;user id=%s;password=%s;database=%s",
+			dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.DBName), nil
+	default:
+		return "", fmt.Errorf("unsupported driver %q", d)
+	}
+}
+
+// extractionKeywords returns the statement keywords this driver's dialect
+// can lead a replayable query with, beyond the ANSI baseline. With
+// allowWrites false (the -allow-writes default), only the read-only
+// SELECT/WITH keywords are returned, so pointing the tool at prod by
+// accident can't extract a mutating statement in the first place.
+func (d Driver) extractionKeywords(allowWrites bool) []string {
+	if !allowWrites {
+		return []string{"SELECT", "WITH"}
+	}
+	keywords := []string{"SELECT", "INSERT", "UPDATE", "DELETE", "WITH RECURSIVE"}
+	switch d {
+	case DriverMySQL:
+		keywords = append(keywords, "REPLACE")
+	case DriverSQLite:
+		keywords = append(keywords, "PRAGMA")
+	case DriverMSSQL, DriverPostgres:
+		keywords = append(keywords, "MERGE")
+	}
+	return keywords
+}
+
+// extractionRegex builds the driver-aware regex used to pull the SQL
+// statement out of a log message, replacing the old hardcoded Postgres
+// keyword list.
+func (d Driver) extractionRegex(allowWrites bool) *regexp.Regexp {
+	pattern := `(?i)\b(`
+	for i, kw := range d.extractionKeywords(allowWrites) {
+		if i > 0 {
+			pattern += "|"
+		}
+		pattern += kw
+	}
+	pattern += `)\b[\s\S]*`
+	return regexp.MustCompile(pattern)
+}
+
+// reForbiddenWrite matches a mutating keyword anywhere in a statement, not
+// just at the start. extractionKeywords only anchors the leading keyword, so
+// a statement like "WITH x AS (DELETE FROM t RETURNING *) SELECT * FROM x"
+// still matches the SELECT/WITH-only regex built for allowWrites=false -
+// sanitizeExtraction catches what that anchor misses.
+var reForbiddenWrite = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE|REPLACE)\b`)
+
+// sanitizeExtraction blanks statement when allowWrites is false and it
+// contains a mutating keyword anywhere in its body, so a write statement
+// smuggled in through a CTE or subquery can't slip past the read-only
+// default the way a leading-keyword check alone would allow.
+func sanitizeExtraction(statement string, allowWrites bool) string {
+	if !allowWrites && reForbiddenWrite.MatchString(statement) {
+		return ""
+	}
+	return statement
+}
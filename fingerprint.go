@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+var (
+	reStringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	reNumberLiteral = regexp.MustCompile(`\b\d+(\.\d+)?\b`)
+	reInList        = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(\s*,\s*\?)*\s*\)`)
+)
+
+// NormalizeQuery strips string/numeric literals and collapses IN (...)
+// lists down to a single placeholder, so that the same parameterized query
+// executed with different literals produces the same template. It's the
+// basis for Fingerprint, and is exposed on its own since the report wants
+// the readable template alongside the hash.
+func NormalizeQuery(query string) string {
+	normalized := reStringLiteral.ReplaceAllString(query, "?")
+	normalized = reNumberLiteral.ReplaceAllString(normalized, "?")
+	normalized = reInList.ReplaceAllString(normalized, "IN (?)")
+	return strings.Join(strings.Fields(normalized), " ")
+}
+
+// Fingerprint hashes a query's normalized template, so log dumps that
+// replay the same parameterized query thousands of times with different
+// literals collapse to a single key for dedupe/sampling/reporting.
+func Fingerprint(query string) string {
+	sum := sha256.Sum256([]byte(NormalizeQuery(query)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// filterByFingerprint wraps entries with -dedupe/-sample N policy: dedupe
+// forwards only the first instance of each fingerprint, sample forwards at
+// most N instances of each. dedupe takes precedence when both are set,
+// since "run once" is a stricter cap than any N. Entries fingerprint to the
+// same key on the pre-extraction log message, matching how the report
+// groups QueryResults afterwards.
+func filterByFingerprint(entries <-chan *LogEntry, dedupe bool, sample int) <-chan *LogEntry {
+	if !dedupe && sample <= 0 {
+		return entries
+	}
+
+	limit := sample
+	if dedupe {
+		limit = 1
+	}
+
+	out := make(chan *LogEntry)
+	go func() {
+		defer close(out)
+		seen := map[string]int{}
+		for entry := range entries {
+			fp := Fingerprint(entry.JsonPayload.Message)
+			if seen[fp] >= limit {
+				continue
+			}
+			seen[fp]++
+			out <- entry
+		}
+	}()
+	return out
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"string literal", "SELECT * FROM users WHERE name = 'alice'", "SELECT * FROM users WHERE name = ?"},
+		{"numeric literal", "SELECT * FROM users WHERE id = 42", "SELECT * FROM users WHERE id = ?"},
+		{"in list", "SELECT * FROM users WHERE id IN (1, 2, 3)", "SELECT * FROM users WHERE id IN (?)"},
+		{"collapses whitespace", "SELECT   *\nFROM users", "SELECT * FROM users"},
+		{"preserves identifiers with digits", "SELECT * FROM table1", "SELECT * FROM table1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeQuery(tt.query); got != tt.want {
+				t.Errorf("NormalizeQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprintGroupsEquivalentQueries(t *testing.T) {
+	a := Fingerprint("SELECT * FROM users WHERE id = 1")
+	b := Fingerprint("SELECT * FROM users WHERE id = 2")
+	if a != b {
+		t.Errorf("expected the same fingerprint for parameterized queries, got %q and %q", a, b)
+	}
+
+	c := Fingerprint("SELECT * FROM orders WHERE id = 1")
+	if a == c {
+		t.Errorf("expected different fingerprints for different query templates")
+	}
+}
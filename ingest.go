@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// isStreamSource reports whether path should be read as newline-delimited
+// JSON (optionally gzip-compressed) through ExtractQueryStream, rather than
+// the legacy single JSON-array format ExtractQuery expects. "-" selects
+// stdin.
+func isStreamSource(path string) bool {
+	if path == "-" {
+		return true
+	}
+	switch {
+	case strings.HasSuffix(path, ".ndjson"), strings.HasSuffix(path, ".jsonl"), strings.HasSuffix(path, ".gz"):
+		return true
+	default:
+		return false
+	}
+}
+
+// openLogSource opens path for reading, treating "-" as stdin and
+// transparently decompressing a .gz suffix.
+func openLogSource(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{gz, f}, nil
+}
+
+// gzipFile closes both the gzip reader and the underlying file it wraps.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// inWindow reports whether entry.Timestamp falls within [since, until). A
+// zero since or until leaves that bound open, so -since/-until can be used
+// independently.
+func inWindow(entry *LogEntry, since, until time.Time) bool {
+	if !since.IsZero() && entry.Timestamp.Before(since) {
+		return false
+	}
+	if !until.IsZero() && !entry.Timestamp.Before(until) {
+		return false
+	}
+	return true
+}
+
+// parseTimeFlag parses an RFC3339 -since/-until flag value, treating an
+// empty string as "no bound".
+func parseTimeFlag(flagName, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-%s: %w", flagName, err)
+	}
+	return t, nil
+}
+
+// ExtractQueryStream reads path as NDJSON (one *LogEntry per line,
+// optionally gzip-compressed, or "-" for stdin) and emits matching entries
+// on the returned channel as they're parsed, instead of buffering the whole
+// file into memory like ExtractQuery. This is the path for multi-GB Cloud
+// Logging exports; the channel feeds the worker pool in QueryConcurrency
+// directly. Parse errors for individual lines are reported on the error
+// channel without stopping the scan; the error channel closes once
+// ingestion finishes.
+func ExtractQueryStream(path string, driver Driver, allowWrites bool, since, until time.Time) (<-chan *LogEntry, <-chan error) {
+	entries := make(chan *LogEntry)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(entries)
+		defer close(errs)
+
+		r, err := openLogSource(path)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer r.Close()
+
+		re := driver.extractionRegex(allowWrites)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var entry LogEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				errs <- fmt.Errorf("parse log line: %w", err)
+				continue
+			}
+			if !inWindow(&entry, since, until) {
+				continue
+			}
+			entry.JsonPayload.Message = sanitizeExtraction(re.FindString(entry.JsonPayload.Message), allowWrites)
+			entries <- &entry
+		}
+		if err := scanner.Err(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return entries, errs
+}
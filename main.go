@@ -1,13 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
 	"sync"
 	"time"
 
@@ -15,34 +15,109 @@ import (
 )
 
 const (
-	_port     = "port"
-	_host     = "host"
-	_user     = "user"
-	_dbname   = "dbname"
-	_password = "password"
-	_sslmode  = "sslmode"
-	_env_file = "env_file"
-	_sql_file = "sql_file"
+	_port          = "port"
+	_host          = "host"
+	_user          = "user"
+	_dbname        = "dbname"
+	_password      = "password"
+	_sslmode       = "sslmode"
+	_env_file      = "env_file"
+	_sql_file      = "sql_file"
+	_workers       = "workers"
+	_query_timeout = "query-timeout"
+	_deadline      = "deadline"
+	_driver        = "driver"
+	_since         = "since"
+	_until         = "until"
+	_mode          = "mode"
+	_bench_n       = "bench-n"
+	_bench_warmup  = "bench-warmup"
+	_report        = "report"
+	_dedupe        = "dedupe"
+	_sample        = "sample"
+	_readonly      = "readonly"
+	_dry_run       = "dry-run"
+	_allow_writes  = "allow-writes"
 )
 
+// defaultMaxOpenConns mirrors the cap set on the pool in Connection, so the
+// default worker count can leave headroom for one connection outside the pool.
+const defaultMaxOpenConns = 70
+
 type config struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
-	EnvFile  string
-	SqlFile  string
+	Host            string
+	Port            string
+	User            string
+	Password        string
+	DBName          string
+	SSLMode         string
+	EnvFile         string
+	SqlFile         string
+	Workers         int
+	QueryTimeout    time.Duration
+	Deadline        time.Duration
+	Driver          Driver
+	Mode            Mode
+	BenchIterations int
+	BenchWarmup     int
+	ReportPath      string
+	Dedupe          bool
+	Sample          int
+	ReadOnly        bool
+	DryRun          bool
+	AllowWrites     bool
+}
+
+// QueryResult captures the outcome of replaying a single log entry, so
+// callers can report on successes and failures instead of aborting on the
+// first error. In -mode=benchmark, Samples holds every successful post-warmup
+// run and Duration is just the last one; in -mode=explain, PlanCost/
+// BufferHits come from the query's EXPLAIN (FORMAT JSON) plan instead of
+// RowsScanned. Err is the last failure seen (if any), kept for
+// printQueryError and the top-level success count; Successes/Failures count
+// individual iterations so a benchmark result with both can't have its
+// Samples conflated with an all-or-nothing outcome.
+type QueryResult struct {
+	Query       string
+	Duration    time.Duration
+	Samples     []time.Duration
+	RowsScanned int
+	PlanCost    float64
+	BufferHits  int
+	Successes   int
+	Failures    int
+	Err         error
+}
+
+// runOptions threads the per-run knobs runQuery needs down from dbConfig
+// without widening its parameter list every time a mode gains a setting.
+type runOptions struct {
+	Mode            Mode
+	QueryTimeout    time.Duration
+	BenchIterations int
+	BenchWarmup     int
+	ReadOnly        bool
+	DryRun          bool
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so executeOnce/runExplain
+// can run against a plain connection or inside a -readonly transaction
+// without duplicating the query logic.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 type LogEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
 	JsonPayload struct {
 		Message string `json:"message"`
 	} `json:"jsonPayload"`
 }
 
-func ExtractQuery(filePath string) ([]*LogEntry, error) {
+// ExtractQuery reads the legacy single JSON-array log export into memory.
+// For multi-GB NDJSON/gzip exports, prefer ExtractQueryStream instead.
+func ExtractQuery(filePath string, driver Driver, allowWrites bool, since, until time.Time) ([]*LogEntry, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
@@ -52,16 +127,32 @@ func ExtractQuery(filePath string) ([]*LogEntry, error) {
 	if err != nil {
 		return nil, err
 	}
-	re := regexp.MustCompile(`(?i)\b(SELECT|INSERT|UPDATE|DELETE|WITH RECURSIVE)\b[\s\S]*`)
+	re := driver.extractionRegex(allowWrites)
+	filtered := logs[:0]
+	for _, l := range logs {
+		if !inWindow(l, since, until) {
+			continue
+		}
+		l.JsonPayload.Message = sanitizeExtraction(re.FindString(l.JsonPayload.Message), allowWrites)
+		filtered = append(filtered, l)
+	}
+	return filtered, nil
+}
+
+// entriesToChannel feeds a pre-loaded slice of entries into a channel, so
+// QueryConcurrency can consume both the batch and streaming ingestion paths
+// through the same interface.
+func entriesToChannel(logs []*LogEntry) <-chan *LogEntry {
+	entries := make(chan *LogEntry, len(logs))
 	for _, l := range logs {
-		sql := re.FindString(l.JsonPayload.Message)
-		l.JsonPayload.Message = sql
+		entries <- l
 	}
-	return logs, nil
+	close(entries)
+	return entries
 }
 
-func Connection(dsn string) (*sql.DB, error) {
-	conn, err := sql.Open("postgres", dsn)
+func Connection(driver Driver, dsn string) (*sql.DB, error) {
+	conn, err := sql.Open(string(driver), dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -72,76 +163,326 @@ func Connection(dsn string) (*sql.DB, error) {
 	return conn, nil
 }
 
-func QueryConcurrency(logs []*LogEntry, dbConfig *config) (int, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=%s", dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.DBName, dbConfig.Password, dbConfig.SSLMode)
-	conn, err := Connection(dsn)
+// printQueryError logs a failed query the way the tool always has, so a
+// user scrolling a run's output can spot and copy the offending statement.
+func printQueryError(query string, err error, duration time.Duration) {
+	fmt.Println("--------------------------------------------------------")
+	fmt.Println("Query error: ", query)
+	fmt.Println(err.Error())
+	fmt.Printf("The total time taken before timeout is %d milisecons.\n", duration.Milliseconds())
+	fmt.Println("--------------------------------------------------------")
+}
+
+// beginQueryer returns conn itself, or, when readOnly is set, a transaction
+// opened with BEGIN TRANSACTION READ ONLY that the caller must roll back
+// once it's done reading. Rolling back is always correct here since a
+// read-only transaction never has anything to commit.
+func beginQueryer(ctx context.Context, conn *sql.DB, readOnly bool) (queryer, func(), error) {
+	if !readOnly {
+		return conn, func() {}, nil
+	}
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, func() {}, err
+	}
+	return tx, func() { tx.Rollback() }, nil
+}
+
+// executeOnce runs statement under a per-query timeout derived from ctx and
+// reports how long it took and how many rows came back. With readOnly set,
+// it runs inside a read-only transaction that is always rolled back, so
+// pointing the tool at prod can't leave behind a mutation even if a write
+// statement slipped past -allow-writes.
+func executeOnce(ctx context.Context, conn *sql.DB, statement string, queryTimeout time.Duration, readOnly bool) (time.Duration, int, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	timeStart := time.Now()
+	q, done, err := beginQueryer(queryCtx, conn, readOnly)
+	if err != nil {
+		return time.Since(timeStart), 0, err
+	}
+	defer done()
+
+	rows, err := q.QueryContext(queryCtx, statement)
+	if err != nil {
+		return time.Since(timeStart), 0, err
+	}
+	defer rows.Close()
+
+	rowCount := 0
+	for rows.Next() {
+		rowCount++
+	}
+	if err := rows.Err(); err != nil {
+		return time.Since(timeStart), rowCount, err
+	}
+	return time.Since(timeStart), rowCount, nil
+}
+
+// runDryRun is -dry-run: validate the statement with PREPARE instead of
+// executing it, so a run can sanity-check a log file against a database
+// without ever running a query against it.
+func runDryRun(ctx context.Context, conn *sql.DB, entry *LogEntry, queryTimeout time.Duration) QueryResult {
+	result := QueryResult{Query: entry.JsonPayload.Message}
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	timeStart := time.Now()
+	stmt, err := conn.PrepareContext(queryCtx, entry.JsonPayload.Message)
+	result.Duration = time.Since(timeStart)
+	if err != nil {
+		result.Err = err
+		result.Failures = 1
+		printQueryError(entry.JsonPayload.Message, err, result.Duration)
+		return result
+	}
+	stmt.Close()
+	result.Successes = 1
+	return result
+}
+
+// runExecute is -mode=execute: run the statement once and report how it
+// went, instead of bubbling the error up and aborting the rest of the run.
+func runExecute(ctx context.Context, conn *sql.DB, entry *LogEntry, opts runOptions) QueryResult {
+	result := QueryResult{Query: entry.JsonPayload.Message}
+	duration, rowCount, err := executeOnce(ctx, conn, entry.JsonPayload.Message, opts.QueryTimeout, opts.ReadOnly)
+	result.Duration = duration
+	if err != nil {
+		result.Err = err
+		result.Failures = 1
+		printQueryError(entry.JsonPayload.Message, err, duration)
+		return result
+	}
+	result.RowsScanned = rowCount
+	result.Successes = 1
+	return result
+}
+
+// runBenchmark is -mode=benchmark: run the statement opts.BenchWarmup +
+// opts.BenchIterations times, discard the warmup runs, and keep every
+// remaining sample so the report can derive a latency distribution.
+func runBenchmark(ctx context.Context, conn *sql.DB, entry *LogEntry, opts runOptions) QueryResult {
+	result := QueryResult{Query: entry.JsonPayload.Message}
+	iterations := opts.BenchIterations
+	if iterations < 1 {
+		iterations = 1
+	}
+	for i := 0; i < opts.BenchWarmup+iterations; i++ {
+		duration, rowCount, err := executeOnce(ctx, conn, entry.JsonPayload.Message, opts.QueryTimeout, opts.ReadOnly)
+		if i < opts.BenchWarmup {
+			continue
+		}
+		if err != nil {
+			result.Err = err
+			result.Failures++
+			printQueryError(entry.JsonPayload.Message, err, duration)
+			continue
+		}
+		result.Successes++
+		result.RowsScanned = rowCount
+		result.Samples = append(result.Samples, duration)
+	}
+	if len(result.Samples) > 0 {
+		result.Duration = result.Samples[len(result.Samples)-1]
+	}
+	return result
+}
+
+// runExplain is -mode=explain: wrap the statement in EXPLAIN (ANALYZE,
+// BUFFERS, FORMAT JSON) and pull the plan's cost and buffer hits out of the
+// returned plan instead of executing it for its own rows.
+func runExplain(ctx context.Context, conn *sql.DB, entry *LogEntry, opts runOptions) QueryResult {
+	result := QueryResult{Query: entry.JsonPayload.Message}
+	statement := "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) " + entry.JsonPayload.Message
+
+	queryCtx, cancel := context.WithTimeout(ctx, opts.QueryTimeout)
+	defer cancel()
+
+	timeStart := time.Now()
+	q, done, err := beginQueryer(queryCtx, conn, opts.ReadOnly)
+	if err != nil {
+		result.Err = err
+		result.Failures = 1
+		result.Duration = time.Since(timeStart)
+		printQueryError(entry.JsonPayload.Message, err, result.Duration)
+		return result
+	}
+	defer done()
+
+	var planJSON string
+	err = q.QueryRowContext(queryCtx, statement).Scan(&planJSON)
+	result.Duration = time.Since(timeStart)
+	if err != nil {
+		result.Err = err
+		result.Failures = 1
+		printQueryError(entry.JsonPayload.Message, err, result.Duration)
+		return result
+	}
+	result.PlanCost, result.BufferHits = parseExplainPlan(planJSON)
+	result.Successes = 1
+	return result
+}
+
+// runQuery dispatches a single log entry to the handler for opts.Mode.
+// -dry-run overrides the mode entirely: it only validates the statement.
+func runQuery(ctx context.Context, conn *sql.DB, entry *LogEntry, opts runOptions) QueryResult {
+	if opts.DryRun {
+		return runDryRun(ctx, conn, entry, opts.QueryTimeout)
+	}
+	switch opts.Mode {
+	case ModeExplain:
+		return runExplain(ctx, conn, entry, opts)
+	case ModeBenchmark:
+		return runBenchmark(ctx, conn, entry, opts)
+	default:
+		return runExecute(ctx, conn, entry, opts)
+	}
+}
+
+// QueryConcurrency replays entries through a bounded worker pool of size
+// dbConfig.Workers, so a large log file can no longer open more goroutines
+// than the connection pool can serve. ctx governs the whole run (see
+// -deadline); each query additionally gets its own -query-timeout. entries
+// may come from a fully-loaded batch (see entriesToChannel) or be fed live
+// by a streaming ingestion path such as ExtractQueryStream.
+func QueryConcurrency(ctx context.Context, entries <-chan *LogEntry, dbConfig *config) ([]QueryResult, error) {
+	dsn, err := dbConfig.Driver.dsn(dbConfig)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	conn, err := Connection(dbConfig.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	workers := dbConfig.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	opts := runOptions{
+		Mode:            dbConfig.Mode,
+		QueryTimeout:    dbConfig.QueryTimeout,
+		BenchIterations: dbConfig.BenchIterations,
+		BenchWarmup:     dbConfig.BenchWarmup,
+		ReadOnly:        dbConfig.ReadOnly,
+		DryRun:          dbConfig.DryRun,
 	}
 
 	var m sync.Mutex
-	var numberSuccess int
+	var results []QueryResult
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(logs))
-	for _, log := range logs {
+
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
-		go func(sqlString string, conn *sql.DB, wg *sync.WaitGroup) {
-			timeStart := time.Now()
+		go func() {
 			defer wg.Done()
-			rows, err := conn.Query(sqlString)
-			if err != nil {
-				fmt.Println("--------------------------------------------------------")
-				fmt.Println("Query error: ", sqlString)
-				fmt.Println(err.Error())
-				fmt.Printf("The total time taken before timeout is %d milisecons.\n", time.Since(timeStart).Milliseconds())
-				fmt.Println("--------------------------------------------------------")
-				errChan <- err
-				return
+			for {
+				select {
+				case entry, ok := <-entries:
+					if !ok {
+						return
+					}
+					r := runQuery(ctx, conn, entry, opts)
+					m.Lock()
+					results = append(results, r)
+					m.Unlock()
+				case <-ctx.Done():
+					return
+				}
 			}
-			m.Lock()
-			numberSuccess = numberSuccess + 1
-			m.Unlock()
-			defer rows.Close()
-		}(log.JsonPayload.Message, conn, &wg)
+		}()
 	}
 	wg.Wait()
-	close(errChan)
-	if len(errChan) > 0 {
-		return 0, <-errChan
+
+	if ctx.Err() != nil {
+		return results, ctx.Err()
 	}
-	return numberSuccess, nil
+	return results, nil
 }
 
 func main() {
 	var (
-		host     = flag.String(_host, "localhost", "database host name")
-		port     = flag.String(_port, "5432", "database port")
-		user     = flag.String(_user, "", "database user name")
-		password = flag.String(_password, "", "database password")
-		sslmode  = flag.String(_sslmode, "disable", "database sslmode")
-		dbname   = flag.String(_dbname, "", "database name")
-		sqlFile  = flag.String(_sql_file, "", "sql query file")
+		host         = flag.String(_host, "localhost", "database host name")
+		port         = flag.String(_port, "5432", "database port")
+		user         = flag.String(_user, "", "database user name")
+		password     = flag.String(_password, "", "database password")
+		sslmode      = flag.String(_sslmode, "disable", "database sslmode")
+		dbname       = flag.String(_dbname, "", "database name")
+		sqlFile      = flag.String(_sql_file, "", "sql query file")
+		workers      = flag.Int(_workers, defaultMaxOpenConns-1, "number of queries to run concurrently")
+		queryTimeout = flag.Duration(_query_timeout, 30*time.Second, "timeout for a single query")
+		deadline     = flag.Duration(_deadline, 0, "overall deadline for the whole run (0 means no deadline)")
+		driverFlag   = flag.String(_driver, string(DriverPostgres), "database driver: postgres, mysql, sqlite3 or sqlserver")
+		sinceFlag    = flag.String(_since, "", "only replay entries at or after this RFC3339 timestamp")
+		untilFlag    = flag.String(_until, "", "only replay entries before this RFC3339 timestamp")
+		modeFlag     = flag.String(_mode, string(ModeExecute), "replay mode: execute, explain or benchmark")
+		benchN       = flag.Int(_bench_n, 10, "benchmark mode: iterations to run per query after warmup")
+		benchWarmup  = flag.Int(_bench_warmup, 2, "benchmark mode: warmup iterations to discard per query")
+		reportPath   = flag.String(_report, "", "write a latency/plan report to this path (.json or .csv)")
+		dedupe       = flag.Bool(_dedupe, false, "run each distinct query fingerprint once instead of every logged occurrence")
+		sample       = flag.Int(_sample, 0, "run at most N instances per query fingerprint (0 means no cap)")
+		readonly     = flag.Bool(_readonly, false, "run every query inside a rolled-back BEGIN TRANSACTION READ ONLY")
+		dryRun       = flag.Bool(_dry_run, false, "only validate statements with PREPARE; never execute them")
+		allowWrites  = flag.Bool(_allow_writes, false, "extract INSERT/UPDATE/DELETE/etc statements too, not just SELECT/WITH")
 	)
 
 	flag.Parse()
 
-	config := config{
-		Host:     *host,
-		Port:     *port,
-		User:     *user,
-		Password: *password,
-		DBName:   *dbname,
-		SSLMode:  *sslmode,
-		SqlFile:  *sqlFile,
+	driver, err := parseDriver(*driverFlag)
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
 
-	flag.Parse()
+	since, err := parseTimeFlag(_since, *sinceFlag)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	until, err := parseTimeFlag(_until, *untilFlag)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	mode, err := parseMode(*modeFlag)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
 
-	if config.User == "" {
-		log.Fatalf("Missing user name")
+	config := config{
+		Host:            *host,
+		Port:            *port,
+		User:            *user,
+		Password:        *password,
+		DBName:          *dbname,
+		SSLMode:         *sslmode,
+		SqlFile:         *sqlFile,
+		Workers:         *workers,
+		QueryTimeout:    *queryTimeout,
+		Deadline:        *deadline,
+		Driver:          driver,
+		Mode:            mode,
+		BenchIterations: *benchN,
+		BenchWarmup:     *benchWarmup,
+		ReportPath:      *reportPath,
+		Dedupe:          *dedupe,
+		Sample:          *sample,
+		ReadOnly:        *readonly,
+		DryRun:          *dryRun,
+		AllowWrites:     *allowWrites,
 	}
-	if config.Password == "" {
-		log.Fatalf("Missing password")
+
+	// DriverSQLite's dsn() treats DBName as a bare file path and never reads
+	// User/Password, so requiring them here would break the "point this at
+	// a local SQLite file for CI" use case.
+	if config.Driver != DriverSQLite {
+		if config.User == "" {
+			log.Fatalf("Missing user name")
+		}
+		if config.Password == "" {
+			log.Fatalf("Missing password")
+		}
 	}
 	if config.DBName == "" {
 		log.Fatalf("Missing database name")
@@ -150,19 +491,66 @@ func main() {
 		log.Fatalf("Missing sql file")
 	}
 
-	// logs, err := ExtractQuery("/Users/genkidev/Desktop/stagging_sql_query.json")
-	logs, err := ExtractQuery(config.SqlFile)
-	if err != nil {
-		panic(err.Error())
+	ctx := context.Background()
+	if config.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Deadline)
+		defer cancel()
 	}
+
+	var entries <-chan *LogEntry
+	var streamErrs <-chan error
+	total := 0
+	if isStreamSource(config.SqlFile) {
+		entries, streamErrs = ExtractQueryStream(config.SqlFile, config.Driver, config.AllowWrites, since, until)
+	} else {
+		// logs, err := ExtractQuery("/Users/genkidev/Desktop/stagging_sql_query.json", config.Driver, config.AllowWrites, since, until)
+		logs, err := ExtractQuery(config.SqlFile, config.Driver, config.AllowWrites, since, until)
+		if err != nil {
+			panic(err.Error())
+		}
+		total = len(logs)
+		entries = entriesToChannel(logs)
+	}
+	entries = filterByFingerprint(entries, config.Dedupe, config.Sample)
+
+	// Drain streamErrs concurrently with QueryConcurrency below: the
+	// ingestion goroutine in ExtractQueryStream writes to entries and errs
+	// on the same goroutine, so if nothing reads errs until after the run
+	// finishes, a second malformed line blocks that goroutine forever on
+	// errs <- err and entries is never closed.
+	if streamErrs != nil {
+		go func() {
+			for streamErr := range streamErrs {
+				fmt.Println("log ingestion error: ", streamErr.Error())
+			}
+		}()
+	}
+
 	timeStart := time.Now()
-	numberSuccess, err := QueryConcurrency(logs, &config)
+	results, err := QueryConcurrency(ctx, entries, &config)
+	if total == 0 || config.Dedupe || config.Sample > 0 {
+		total = len(results)
+	}
+
+	numberSuccess := 0
+	for _, r := range results {
+		if r.Failures == 0 {
+			numberSuccess++
+		}
+	}
 
 	fmt.Println("****************************************")
-	fmt.Printf("%d successful requests out of %d requests.\n ", numberSuccess, len(logs))
+	fmt.Printf("%d successful requests out of %d requests.\n ", numberSuccess, total)
 	fmt.Println("****************************************")
 	fmt.Printf("timeconsuming: %d milisecon\n", time.Since(timeStart).Milliseconds())
 
+	if config.ReportPath != "" {
+		if reportErr := writeReport(config.ReportPath, buildReport(results)); reportErr != nil {
+			fmt.Println("failed to write report: ", reportErr.Error())
+		}
+	}
+
 	if err != nil {
 		panic(err.Error())
 	}
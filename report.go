@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode selects how a log entry's query is replayed.
+type Mode string
+
+const (
+	ModeExecute   Mode = "execute"
+	ModeExplain   Mode = "explain"
+	ModeBenchmark Mode = "benchmark"
+)
+
+func parseMode(name string) (Mode, error) {
+	switch Mode(name) {
+	case ModeExecute, ModeExplain, ModeBenchmark:
+		return Mode(name), nil
+	default:
+		return "", fmt.Errorf("unsupported mode %q (want one of execute, explain, benchmark)", name)
+	}
+}
+
+// parseExplainPlan pulls the total cost and shared-buffer hit count out of
+// a Postgres EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) plan.
+func parseExplainPlan(planJSON string) (cost float64, bufferHits int) {
+	var plans []struct {
+		Plan struct {
+			TotalCost       float64 `json:"Total Cost"`
+			SharedHitBlocks int     `json:"Shared Hit Blocks"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(planJSON), &plans); err != nil || len(plans) == 0 {
+		return 0, 0
+	}
+	return plans[0].Plan.TotalCost, plans[0].Plan.SharedHitBlocks
+}
+
+// percentile returns the p-th percentile (0..1) of samples. It sorts a copy
+// each call, which is fine at the sample counts a single replay run
+// produces; a true streaming histogram isn't worth the complexity here.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+// ReportEntry aggregates every QueryResult sharing a fingerprint into the
+// distribution and metadata a -report file needs, so a dump where the same
+// parameterized query ran thousands of times reads as one row ("this
+// template ran 12k times, 3% failed, p95=420ms") instead of a flat count.
+type ReportEntry struct {
+	Fingerprint  string
+	Template     string
+	Query        string
+	Count        int
+	ErrorCount   int
+	MinMs        int64
+	P50Ms        int64
+	P95Ms        int64
+	P99Ms        int64
+	MaxMs        int64
+	PlanCost     float64
+	BufferHits   int
+	RowsReturned int
+}
+
+// buildReport groups results by query fingerprint and derives one
+// ReportEntry per group, turning the current pass/fail counter into a real
+// per-template breakdown.
+func buildReport(results []QueryResult) []ReportEntry {
+	type group struct {
+		entry   ReportEntry
+		samples []time.Duration
+	}
+	groups := map[string]*group{}
+	var order []string
+
+	for _, r := range results {
+		fp := Fingerprint(r.Query)
+		g, ok := groups[fp]
+		if !ok {
+			g = &group{entry: ReportEntry{Fingerprint: fp, Template: NormalizeQuery(r.Query), Query: r.Query}}
+			groups[fp] = g
+			order = append(order, fp)
+		}
+		g.entry.Count++
+		g.entry.ErrorCount += r.Failures
+		if len(r.Samples) > 0 {
+			g.samples = append(g.samples, r.Samples...)
+		} else if r.Successes > 0 {
+			g.samples = append(g.samples, r.Duration)
+		}
+		g.entry.RowsReturned += r.RowsScanned
+		if r.PlanCost > 0 {
+			g.entry.PlanCost = r.PlanCost
+		}
+		if r.BufferHits > 0 {
+			g.entry.BufferHits = r.BufferHits
+		}
+	}
+
+	entries := make([]ReportEntry, 0, len(order))
+	for _, fp := range order {
+		g := groups[fp]
+		g.entry.MinMs = percentile(g.samples, 0).Milliseconds()
+		g.entry.P50Ms = percentile(g.samples, 0.5).Milliseconds()
+		g.entry.P95Ms = percentile(g.samples, 0.95).Milliseconds()
+		g.entry.P99Ms = percentile(g.samples, 0.99).Milliseconds()
+		g.entry.MaxMs = percentile(g.samples, 1).Milliseconds()
+		entries = append(entries, g.entry)
+	}
+	return entries
+}
+
+// writeReport writes entries as JSON or CSV based on path's extension.
+func writeReport(path string, entries []ReportEntry) error {
+	if strings.HasSuffix(path, ".csv") {
+		return writeReportCSV(path, entries)
+	}
+	return writeReportJSON(path, entries)
+}
+
+func writeReportJSON(path string, entries []ReportEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func writeReportCSV(path string, entries []ReportEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+
+	header := []string{"fingerprint", "template", "query", "count", "error_count", "min_ms", "p50_ms", "p95_ms", "p99_ms", "max_ms", "plan_cost", "buffer_hits", "rows_returned"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		row := []string{
+			e.Fingerprint,
+			e.Template,
+			e.Query,
+			strconv.Itoa(e.Count),
+			strconv.Itoa(e.ErrorCount),
+			strconv.FormatInt(e.MinMs, 10),
+			strconv.FormatInt(e.P50Ms, 10),
+			strconv.FormatInt(e.P95Ms, 10),
+			strconv.FormatInt(e.P99Ms, 10),
+			strconv.FormatInt(e.MaxMs, 10),
+			strconv.FormatFloat(e.PlanCost, 'f', -1, 64),
+			strconv.Itoa(e.BufferHits),
+			strconv.Itoa(e.RowsReturned),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	// Flush explicitly (not via defer) so a flush failure is reflected in
+	// the error we return, instead of being checked before the write
+	// actually happens.
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	samples := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		30 * time.Millisecond,
+		40 * time.Millisecond,
+		50 * time.Millisecond,
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 10 * time.Millisecond},
+		{0.5, 30 * time.Millisecond},
+		{1, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(samples, tt.p); got != tt.want {
+			t.Errorf("percentile(samples, %v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestPercentileEmpty(t *testing.T) {
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile(nil, 0.5) = %v, want 0", got)
+	}
+}
+
+func TestBuildReportKeepsSamplesAlongsideFailures(t *testing.T) {
+	query := "SELECT * FROM users WHERE id = 1"
+	results := []QueryResult{
+		{
+			Query:     query,
+			Successes: 9,
+			Failures:  1,
+			Samples: []time.Duration{
+				10 * time.Millisecond,
+				20 * time.Millisecond,
+				30 * time.Millisecond,
+				40 * time.Millisecond,
+				50 * time.Millisecond,
+				60 * time.Millisecond,
+				70 * time.Millisecond,
+				80 * time.Millisecond,
+				90 * time.Millisecond,
+			},
+		},
+	}
+
+	entries := buildReport(results)
+	if len(entries) != 1 {
+		t.Fatalf("buildReport() returned %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Count != 1 {
+		t.Errorf("Count = %d, want 1", entry.Count)
+	}
+	if entry.ErrorCount != 1 {
+		t.Errorf("ErrorCount = %d, want 1", entry.ErrorCount)
+	}
+	if entry.MinMs != 10 || entry.MaxMs != 90 {
+		t.Errorf("MinMs/MaxMs = %d/%d, want 10/90 (failure shouldn't discard the successful samples)", entry.MinMs, entry.MaxMs)
+	}
+}